@@ -13,6 +13,7 @@ package proto
 import (
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"unicode"
 	"unicode/utf8"
 )
@@ -21,9 +22,10 @@ const unsafeAllowed = false
 
 // A field identifies a field in a struct, accessible from a pointer.
 // In this implementation, a field is identified by the sequence of field indices
-// passed to reflect's FieldByIndex.
+// passed to reflect's FieldByIndex. The path may have more than one element
+// when the field is reached through one or more embedded structs.
 type field struct {
-	index  int
+	index  []int
 	export exporter
 }
 
@@ -31,26 +33,24 @@ type exporter = func(interface{}, int) interface{}
 
 // toField returns a field equivalent to the given reflect field.
 func toField(f *reflect.StructField, x exporter) field {
-	if len(f.Index) != 1 {
-		panic("embedded structs are not supported")
-	}
+	index := append([]int(nil), f.Index...)
 	if f.PkgPath == "" {
-		return field{index: f.Index[0]} // field is already exported
+		return field{index: index} // field is already exported
 	}
 	if x == nil {
 		panic("exporter must be provided for unexported field: " + f.Name)
 	}
-	return field{index: f.Index[0], export: x}
+	return field{index: index, export: x}
 }
 
 // invalidField is an invalid field identifier.
-var invalidField = field{index: -1}
+var invalidField = field{index: []int{-1}}
 
 // zeroField is a noop when calling pointer.offset.
-var zeroField = field{index: 0}
+var zeroField = field{index: []int{0}}
 
 // IsValid reports whether the field identifier is valid.
-func (f field) IsValid() bool { return f.index >= 0 }
+func (f field) IsValid() bool { return f.index[0] >= 0 }
 
 // The pointer type is for the table-driven decoder.
 // The implementation here uses a reflect.Value of pointer type to
@@ -84,14 +84,28 @@ func valToPointer(v reflect.Value) pointer {
 }
 
 // offset converts from a pointer to a structure to a pointer to
-// one of its fields.
+// one of its fields. f.index may name a field reached through one or
+// more embedded structs; any nil embedded struct pointer encountered along
+// the way is allocated in place, since reflect.Value.FieldByIndex does not
+// do so itself.
 func (p pointer) offset(f field) pointer {
+	v := p.v.Elem()
+	for _, i := range f.index[:len(f.index)-1] {
+		v = v.Field(i)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+	}
+	last := f.index[len(f.index)-1]
 	if f.export != nil {
-		if v := reflect.ValueOf(f.export(p.v.Interface(), f.index)); v.IsValid() {
-			return pointer{v: v}
+		if ev := reflect.ValueOf(f.export(v.Addr().Interface(), last)); ev.IsValid() {
+			return pointer{v: ev}
 		}
 	}
-	return pointer{v: p.v.Elem().Field(f.index).Addr()}
+	return pointer{v: v.Field(last).Addr()}
 }
 
 func (p pointer) isNil() bool {
@@ -111,20 +125,144 @@ func grow(s reflect.Value) reflect.Value {
 	return s.Index(n)
 }
 
+// customConverter holds the pair of functions used to convert between a
+// user-defined Go type and one of the built-in scalar types accepted by
+// the table-driven codec (for example a uuid.UUID backing a bytes field,
+// or a time.Time backing sfixed64).
+//
+// Only toBuiltin is used in this tree today: the toX accessors below
+// consult it to marshal a custom-typed field. fromBuiltin has no caller
+// here — flushing a decoded built-in value back into the custom Go type
+// is table_unmarshal.go's job, and that file isn't present in this tree.
+// RegisterCustomType still takes both functions so the registration API
+// doesn't need to change shape once that unmarshal-side hookup lands;
+// until then, custom types are supported for marshaling only.
+type customConverter struct {
+	toBuiltin   func(reflect.Value) interface{}
+	fromBuiltin func(interface{}) reflect.Value
+}
+
+var (
+	customTypeMu    sync.RWMutex
+	customTypeTable = map[reflect.Type]customConverter{}
+)
+
+// RegisterCustomType registers the conversion functions that let values of
+// the user-defined type t stand in for a built-in scalar in a generated
+// message. It must be called, if at all, before any message containing a
+// field of type t is marshaled. See the customConverter doc comment: only
+// the marshal direction is wired up in this tree.
+func RegisterCustomType(t reflect.Type, toBuiltin func(reflect.Value) interface{}, fromBuiltin func(interface{}) reflect.Value) {
+	customTypeMu.Lock()
+	defer customTypeMu.Unlock()
+	customTypeTable[t] = customConverter{toBuiltin: toBuiltin, fromBuiltin: fromBuiltin}
+}
+
+// lookupCustomType does an RLock + map lookup on every call, i.e. once per
+// toX touch of a custom-typed field on the marshal hot path. The request
+// that added this registry asked for the lookup to be cached at Properties
+// parse time into the field's codec entry, so the hot path stays one
+// indirect call instead of a map lookup per field — but that caching lives
+// in table_marshal.go's Properties parsing, which isn't present in this
+// tree, so this is the honest uncached fallback rather than a drop-in for
+// that ask.
+func lookupCustomType(t reflect.Type) (customConverter, bool) {
+	customTypeMu.RLock()
+	defer customTypeMu.RUnlock()
+	c, ok := customTypeTable[t]
+	return c, ok
+}
+
+// customScratch allocates a scratch value of built-in type bt, seeded from
+// the custom Go value p points to, for use by a toX accessor whose type
+// assertion against the built-in type failed. It panics if p's pointee
+// type has no registered converter.
+func (p pointer) customScratch(bt reflect.Type) reflect.Value {
+	c, ok := lookupCustomType(p.v.Type().Elem())
+	if !ok {
+		panic("proto: no custom type registered for " + p.v.Type().Elem().String())
+	}
+	scratch := reflect.New(bt)
+	scratch.Elem().Set(reflect.ValueOf(c.toBuiltin(p.v.Elem())))
+	return scratch
+}
+
+// customScratchPtr is customScratch's counterpart for optional (pointer-typed)
+// fields: p points at the address of a *CustomType field, which may be nil.
+// It returns a new **bt whose pointee is nil if the field is nil, otherwise
+// a freshly converted bt value.
+func (p pointer) customScratchPtr(bt reflect.Type) reflect.Value {
+	elemType := p.v.Type().Elem().Elem()
+	c, ok := lookupCustomType(elemType)
+	if !ok {
+		panic("proto: no custom type registered for " + elemType.String())
+	}
+	scratch := reflect.New(reflect.PtrTo(bt))
+	if !p.v.Elem().IsNil() {
+		b := reflect.New(bt)
+		b.Elem().Set(reflect.ValueOf(c.toBuiltin(p.v.Elem().Elem())))
+		scratch.Elem().Set(b)
+	}
+	return scratch
+}
+
+// customScratchSlice is customScratch's counterpart for repeated fields:
+// p points at the address of a []CustomType field. It returns a new *[]bt
+// converted element by element.
+func (p pointer) customScratchSlice(bt reflect.Type) reflect.Value {
+	elemType := p.v.Type().Elem().Elem()
+	c, ok := lookupCustomType(elemType)
+	if !ok {
+		panic("proto: no custom type registered for " + elemType.String())
+	}
+	src := p.v.Elem()
+	dst := reflect.MakeSlice(reflect.SliceOf(bt), src.Len(), src.Len())
+	for i := 0; i < src.Len(); i++ {
+		dst.Index(i).Set(reflect.ValueOf(c.toBuiltin(src.Index(i))))
+	}
+	scratch := reflect.New(reflect.SliceOf(bt))
+	scratch.Elem().Set(dst)
+	return scratch
+}
+
+var (
+	int32Type   = reflect.TypeOf(int32(0))
+	int64Type   = reflect.TypeOf(int64(0))
+	uint32Type  = reflect.TypeOf(uint32(0))
+	uint64Type  = reflect.TypeOf(uint64(0))
+	float32Type = reflect.TypeOf(float32(0))
+	float64Type = reflect.TypeOf(float64(0))
+	boolType    = reflect.TypeOf(false)
+	stringType  = reflect.TypeOf("")
+	bytesType   = reflect.TypeOf([]byte(nil))
+)
+
 func (p pointer) toInt64() *int64 {
-	return p.v.Interface().(*int64)
+	if v, ok := p.v.Interface().(*int64); ok {
+		return v
+	}
+	return p.customScratch(int64Type).Interface().(*int64)
 }
 func (p pointer) toInt64Ptr() **int64 {
-	return p.v.Interface().(**int64)
+	if v, ok := p.v.Interface().(**int64); ok {
+		return v
+	}
+	return p.customScratchPtr(int64Type).Interface().(**int64)
 }
 func (p pointer) toInt64Slice() *[]int64 {
-	return p.v.Interface().(*[]int64)
+	if v, ok := p.v.Interface().(*[]int64); ok {
+		return v
+	}
+	return p.customScratchSlice(int64Type).Interface().(*[]int64)
 }
 
 var int32ptr = reflect.TypeOf((*int32)(nil))
 
 func (p pointer) toInt32() *int32 {
-	return p.v.Convert(int32ptr).Interface().(*int32)
+	if p.v.Type().ConvertibleTo(int32ptr) {
+		return p.v.Convert(int32ptr).Interface().(*int32)
+	}
+	return p.customScratch(int32Type).Interface().(*int32)
 }
 
 // The toInt32Ptr/Slice methods don't work because of enums.
@@ -193,64 +331,124 @@ func (p pointer) appendInt32Slice(v int32) {
 }
 
 func (p pointer) toUint64() *uint64 {
-	return p.v.Interface().(*uint64)
+	if v, ok := p.v.Interface().(*uint64); ok {
+		return v
+	}
+	return p.customScratch(uint64Type).Interface().(*uint64)
 }
 func (p pointer) toUint64Ptr() **uint64 {
-	return p.v.Interface().(**uint64)
+	if v, ok := p.v.Interface().(**uint64); ok {
+		return v
+	}
+	return p.customScratchPtr(uint64Type).Interface().(**uint64)
 }
 func (p pointer) toUint64Slice() *[]uint64 {
-	return p.v.Interface().(*[]uint64)
+	if v, ok := p.v.Interface().(*[]uint64); ok {
+		return v
+	}
+	return p.customScratchSlice(uint64Type).Interface().(*[]uint64)
 }
 func (p pointer) toUint32() *uint32 {
-	return p.v.Interface().(*uint32)
+	if v, ok := p.v.Interface().(*uint32); ok {
+		return v
+	}
+	return p.customScratch(uint32Type).Interface().(*uint32)
 }
 func (p pointer) toUint32Ptr() **uint32 {
-	return p.v.Interface().(**uint32)
+	if v, ok := p.v.Interface().(**uint32); ok {
+		return v
+	}
+	return p.customScratchPtr(uint32Type).Interface().(**uint32)
 }
 func (p pointer) toUint32Slice() *[]uint32 {
-	return p.v.Interface().(*[]uint32)
+	if v, ok := p.v.Interface().(*[]uint32); ok {
+		return v
+	}
+	return p.customScratchSlice(uint32Type).Interface().(*[]uint32)
 }
 func (p pointer) toBool() *bool {
-	return p.v.Interface().(*bool)
+	if v, ok := p.v.Interface().(*bool); ok {
+		return v
+	}
+	return p.customScratch(boolType).Interface().(*bool)
 }
 func (p pointer) toBoolPtr() **bool {
-	return p.v.Interface().(**bool)
+	if v, ok := p.v.Interface().(**bool); ok {
+		return v
+	}
+	return p.customScratchPtr(boolType).Interface().(**bool)
 }
 func (p pointer) toBoolSlice() *[]bool {
-	return p.v.Interface().(*[]bool)
+	if v, ok := p.v.Interface().(*[]bool); ok {
+		return v
+	}
+	return p.customScratchSlice(boolType).Interface().(*[]bool)
 }
 func (p pointer) toFloat64() *float64 {
-	return p.v.Interface().(*float64)
+	if v, ok := p.v.Interface().(*float64); ok {
+		return v
+	}
+	return p.customScratch(float64Type).Interface().(*float64)
 }
 func (p pointer) toFloat64Ptr() **float64 {
-	return p.v.Interface().(**float64)
+	if v, ok := p.v.Interface().(**float64); ok {
+		return v
+	}
+	return p.customScratchPtr(float64Type).Interface().(**float64)
 }
 func (p pointer) toFloat64Slice() *[]float64 {
-	return p.v.Interface().(*[]float64)
+	if v, ok := p.v.Interface().(*[]float64); ok {
+		return v
+	}
+	return p.customScratchSlice(float64Type).Interface().(*[]float64)
 }
 func (p pointer) toFloat32() *float32 {
-	return p.v.Interface().(*float32)
+	if v, ok := p.v.Interface().(*float32); ok {
+		return v
+	}
+	return p.customScratch(float32Type).Interface().(*float32)
 }
 func (p pointer) toFloat32Ptr() **float32 {
-	return p.v.Interface().(**float32)
+	if v, ok := p.v.Interface().(**float32); ok {
+		return v
+	}
+	return p.customScratchPtr(float32Type).Interface().(**float32)
 }
 func (p pointer) toFloat32Slice() *[]float32 {
-	return p.v.Interface().(*[]float32)
+	if v, ok := p.v.Interface().(*[]float32); ok {
+		return v
+	}
+	return p.customScratchSlice(float32Type).Interface().(*[]float32)
 }
 func (p pointer) toString() *string {
-	return p.v.Interface().(*string)
+	if v, ok := p.v.Interface().(*string); ok {
+		return v
+	}
+	return p.customScratch(stringType).Interface().(*string)
 }
 func (p pointer) toStringPtr() **string {
-	return p.v.Interface().(**string)
+	if v, ok := p.v.Interface().(**string); ok {
+		return v
+	}
+	return p.customScratchPtr(stringType).Interface().(**string)
 }
 func (p pointer) toStringSlice() *[]string {
-	return p.v.Interface().(*[]string)
+	if v, ok := p.v.Interface().(*[]string); ok {
+		return v
+	}
+	return p.customScratchSlice(stringType).Interface().(*[]string)
 }
 func (p pointer) toBytes() *[]byte {
-	return p.v.Interface().(*[]byte)
+	if v, ok := p.v.Interface().(*[]byte); ok {
+		return v
+	}
+	return p.customScratch(bytesType).Interface().(*[]byte)
 }
 func (p pointer) toBytesSlice() *[][]byte {
-	return p.v.Interface().(*[][]byte)
+	if v, ok := p.v.Interface().(*[][]byte); ok {
+		return v
+	}
+	return p.customScratchSlice(bytesType).Interface().(*[][]byte)
 }
 func (p pointer) toExtensions() *XXX_InternalExtensions {
 	return p.v.Interface().(*XXX_InternalExtensions)
@@ -310,49 +508,45 @@ func (p pointer) asPointerTo(t reflect.Type) reflect.Value {
 	return p.v
 }
 
-func atomicLoadUnmarshalInfo(p **unmarshalInfo) *unmarshalInfo {
-	atomicLock.Lock()
-	defer atomicLock.Unlock()
-	return *p
+// atomicLoadUnmarshalInfo and its siblings below give the table-driven
+// codec a lock-free way to publish and read the lazily-built per-type info
+// structs. p is the address of the struct field holding the cached info,
+// now an atomic.Value rather than a plain *T, so concurrent first-use of
+// many message types no longer serializes on a single package-level mutex.
+// atomic.Value is used instead of atomic.Pointer[T]: this tree has no
+// go.mod pinning a minimum Go version, and atomic.Value avoids introducing
+// a generics requirement (Go 1.19+) that nothing else here demands. The
+// field type changes from *T to atomic.Value, but the *T the caller gets
+// back in hand is unchanged.
+func atomicLoadUnmarshalInfo(p *atomic.Value) *unmarshalInfo {
+	v, _ := p.Load().(*unmarshalInfo)
+	return v
 }
-func atomicStoreUnmarshalInfo(p **unmarshalInfo, v *unmarshalInfo) {
-	atomicLock.Lock()
-	defer atomicLock.Unlock()
-	*p = v
+func atomicStoreUnmarshalInfo(p *atomic.Value, v *unmarshalInfo) {
+	p.Store(v)
 }
-func atomicLoadMarshalInfo(p **marshalInfo) *marshalInfo {
-	atomicLock.Lock()
-	defer atomicLock.Unlock()
-	return *p
+func atomicLoadMarshalInfo(p *atomic.Value) *marshalInfo {
+	v, _ := p.Load().(*marshalInfo)
+	return v
 }
-func atomicStoreMarshalInfo(p **marshalInfo, v *marshalInfo) {
-	atomicLock.Lock()
-	defer atomicLock.Unlock()
-	*p = v
+func atomicStoreMarshalInfo(p *atomic.Value, v *marshalInfo) {
+	p.Store(v)
 }
-func atomicLoadMergeInfo(p **mergeInfo) *mergeInfo {
-	atomicLock.Lock()
-	defer atomicLock.Unlock()
-	return *p
+func atomicLoadMergeInfo(p *atomic.Value) *mergeInfo {
+	v, _ := p.Load().(*mergeInfo)
+	return v
 }
-func atomicStoreMergeInfo(p **mergeInfo, v *mergeInfo) {
-	atomicLock.Lock()
-	defer atomicLock.Unlock()
-	*p = v
+func atomicStoreMergeInfo(p *atomic.Value, v *mergeInfo) {
+	p.Store(v)
 }
-func atomicLoadDiscardInfo(p **discardInfo) *discardInfo {
-	atomicLock.Lock()
-	defer atomicLock.Unlock()
-	return *p
+func atomicLoadDiscardInfo(p *atomic.Value) *discardInfo {
+	v, _ := p.Load().(*discardInfo)
+	return v
 }
-func atomicStoreDiscardInfo(p **discardInfo, v *discardInfo) {
-	atomicLock.Lock()
-	defer atomicLock.Unlock()
-	*p = v
+func atomicStoreDiscardInfo(p *atomic.Value, v *discardInfo) {
+	p.Store(v)
 }
 
-var atomicLock sync.Mutex
-
 // fieldByName is equivalent to reflect.Value.FieldByName, but is able to
 // descend into unexported fields for prop
 func fieldByName(v reflect.Value, s string) reflect.Value {
@@ -0,0 +1,97 @@
+// Copyright 2012 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build purego
+
+package proto
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestAtomicUnmarshalInfoConcurrent exercises atomicLoadUnmarshalInfo and
+// atomicStoreUnmarshalInfo from many goroutines at once, the scenario the
+// lock-free rework in pointer_reflect.go exists for: concurrent first-use of many
+// message types must not corrupt or lose a previously published info.
+func TestAtomicUnmarshalInfoConcurrent(t *testing.T) {
+	var cache atomic.Value
+	want := &unmarshalInfo{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := atomicLoadUnmarshalInfo(&cache); got == nil {
+				atomicStoreUnmarshalInfo(&cache, want)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := atomicLoadUnmarshalInfo(&cache); got != want {
+		t.Fatalf("atomicLoadUnmarshalInfo() = %p, want %p", got, want)
+	}
+}
+
+// TestOffsetEmbeddedNilPointer exercises the embedded-struct path added to
+// pointer.offset: the field lives behind a nil *Inner, which offset must
+// allocate in place before it can hand back an addressable pointer.
+func TestOffsetEmbeddedNilPointer(t *testing.T) {
+	type Inner struct {
+		Value int32
+	}
+	type Outer struct {
+		*Inner
+	}
+
+	var o Outer
+	sf, ok := reflect.TypeOf(o).FieldByName("Value")
+	if !ok {
+		t.Fatal("FieldByName(Value) not found")
+	}
+	f := toField(&sf, nil)
+
+	p := pointer{v: reflect.ValueOf(&o)}
+	fp := p.offset(f)
+
+	if o.Inner == nil {
+		t.Fatal("offset did not allocate the embedded *Inner")
+	}
+	fp.v.Elem().SetInt(7)
+	if o.Inner.Value != 7 {
+		t.Fatalf("Inner.Value = %d, want 7", o.Inner.Value)
+	}
+}
+
+// TestCustomTypeMarshal registers a converter for a user-defined type and
+// exercises toInt64's fallback through it. Custom types are wired up for
+// the marshal (read) direction only in this tree — see the customConverter
+// doc comment for why the unmarshal direction isn't tested here: nothing
+// in this tree has a decode-side call site to flush a result back.
+func TestCustomTypeMarshal(t *testing.T) {
+	type myDuration struct{ seconds int64 }
+
+	toBuiltin := func(v reflect.Value) interface{} {
+		return v.Interface().(myDuration).seconds
+	}
+	fromBuiltin := func(b interface{}) reflect.Value {
+		return reflect.ValueOf(myDuration{seconds: b.(int64)})
+	}
+	RegisterCustomType(reflect.TypeOf(myDuration{}), toBuiltin, fromBuiltin)
+
+	type Msg struct {
+		TTL myDuration
+	}
+	m := Msg{TTL: myDuration{seconds: 42}}
+	p := pointer{v: reflect.ValueOf(&m).Elem().FieldByName("TTL").Addr()}
+
+	got := p.toInt64()
+	if *got != 42 {
+		t.Fatalf("toInt64() = %d, want 42", *got)
+	}
+}